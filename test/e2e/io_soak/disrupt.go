@@ -0,0 +1,486 @@
+package io_soak
+
+import (
+	"e2e-basic/common"
+	"e2e-basic/common/e2e_config"
+
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DisruptorKind identifies the category of fault a disruptor injects.
+type DisruptorKind string
+
+const (
+	DisruptNetworkPartition DisruptorKind = "network-partition"
+	DisruptNodeReboot       DisruptorKind = "node-reboot"
+	DisruptChaosEviction    DisruptorKind = "chaos-eviction"
+)
+
+// DisruptionEvent records a single fault injection so that monitor() can
+// correlate an IO or pod failure against an intentional disruption rather
+// than treat it as a genuine bug. Target holds the node name for the
+// node-level disruptors and the evicted pod's name for DisruptChaosEviction,
+// since an eviction targets one pod rather than everything on a node.
+type DisruptionEvent struct {
+	Kind    DisruptorKind
+	Target  string
+	Seq     int64
+	Started time.Time
+	Ended   time.Time
+}
+
+var (
+	disruptionLogMutex sync.Mutex
+	disruptionLog      []*DisruptionEvent
+	disruptionSeq      int64
+)
+
+// recordDisruption appends to the shared disruption log; disruptors run as
+// background goroutines so access is serialised. It is called with Ended
+// still zero, at the start of the disruption window, so that monitor() can
+// already find and attribute a pod failure observed while the disruption is
+// still in progress; endDisruption fills in Ended once it's over.
+func recordDisruption(ev *DisruptionEvent) {
+	disruptionLogMutex.Lock()
+	defer disruptionLogMutex.Unlock()
+	disruptionLog = append(disruptionLog, ev)
+}
+
+// endDisruption stamps ev.Ended now that its disruption window is over.
+// Takes the same lock as recordDisruption/disruptedAt so the write can't
+// race a concurrent read of ev.Ended.
+func endDisruption(ev *DisruptionEvent, ended time.Time) {
+	disruptionLogMutex.Lock()
+	defer disruptionLogMutex.Unlock()
+	ev.Ended = ended
+}
+
+// nextDisruptionSeq returns a monotonic sequence number shared by every
+// disruptor, so a chaos eviction schedule can be reconstructed in the
+// order events actually happened even when several disruptors run
+// concurrently.
+func nextDisruptionSeq() int64 {
+	return atomic.AddInt64(&disruptionSeq, 1)
+}
+
+// kindMatches reports whether kind is one of kinds, or kinds is empty
+// (meaning "any kind").
+func kindMatches(kind DisruptorKind, kinds []DisruptorKind) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// disruptionCovers reports whether ev's window, [Started, Ended], covers
+// instant "at". A zero Ended means the disruption is still in progress, so
+// it covers every instant up to now.
+func disruptionCovers(ev *DisruptionEvent, at time.Time) bool {
+	end := ev.Ended
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return !at.Before(ev.Started) && !at.After(end)
+}
+
+// disruptedAt reports whether target (a node name, or a pod name for
+// chaos evictions) had an active, intentional disruption of the given
+// kinds covering instant "at", so a pod failure observed at that time can
+// be attributed to the disruption rather than flagged as a bug.
+func disruptedAt(target string, at time.Time, kinds ...DisruptorKind) bool {
+	disruptionLogMutex.Lock()
+	defer disruptionLogMutex.Unlock()
+	for _, ev := range disruptionLog {
+		if ev.Target != target || !kindMatches(ev.Kind, kinds) {
+			continue
+		}
+		if disruptionCovers(ev, at) {
+			return true
+		}
+	}
+	return false
+}
+
+// disruptionActiveAt reports whether any disruption of the given kinds was
+// active at instant "at", regardless of which target it was applied to. A
+// node-level disruption's Target is the node whose routing/kubelet was
+// disrupted, which is often not the node a given test pod happens to run
+// on (the pod's volume replica/nexus can live elsewhere), so attributing a
+// node-level failure correctly needs a time-window check rather than a
+// target match.
+func disruptionActiveAt(at time.Time, kinds ...DisruptorKind) bool {
+	disruptionLogMutex.Lock()
+	defer disruptionLogMutex.Unlock()
+	for _, ev := range disruptionLog {
+		if !kindMatches(ev.Kind, kinds) {
+			continue
+		}
+		if disruptionCovers(ev, at) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	disruptorWg     sync.WaitGroup
+	disruptorStopCh chan struct{}
+	disruptNodes    []string
+	disruptReplicas int
+)
+
+// DisruptorsInit selects the nodes infrastructure disruptors may target for
+// this run and resets the shared disruption log.
+//
+// Targets are chosen deterministically from the sorted Mayastor node list so
+// that repeated runs against the same cluster disrupt the same nodes. How
+// many of them are actually disrupted at once is decided later, in
+// MakeDisruptors, from the per-disruptor config rather than here.
+func DisruptorsInit(ctx context.Context, protocols []common.ShareProto, replicas int) {
+	log := logr.FromContext(ctx)
+	disruptionLog = nil
+	disruptorStopCh = make(chan struct{})
+	disruptReplicas = replicas
+
+	nodeList, err := common.GetNodeLocs()
+	if err != nil {
+		log.Info("DisruptorsInit: failed to get node list", "error", err)
+		return
+	}
+	sort.Slice(nodeList, func(i, j int) bool { return nodeList[i].NodeName < nodeList[j].NodeName })
+
+	disruptNodes = nil
+	for _, node := range nodeList {
+		if node.MayastorNode && !node.MasterNode {
+			disruptNodes = append(disruptNodes, node.NodeName)
+		}
+	}
+	log.Info("DisruptorsInit", "candidates", disruptNodes, "replicas", replicas)
+}
+
+// disruptTargets returns the prefix of disruptNodes that a node-level
+// disruptor may run against concurrently: maxConcurrent, bounded by
+// replicas-1 so that every volume keeps at least one healthy replica even
+// if a config asks for more concurrent faults than that. Shared by
+// networkPartitionTargets and nodeRebootTargets: both take a prefix of the
+// same sorted list, so the nodes the two kinds target together are never
+// more than replicas-1 either.
+func disruptTargets(maxConcurrent int) []string {
+	if repCap := disruptReplicas - 1; repCap < maxConcurrent {
+		maxConcurrent = repCap
+	}
+	if maxConcurrent < 0 {
+		maxConcurrent = 0
+	}
+	if maxConcurrent > len(disruptNodes) {
+		maxConcurrent = len(disruptNodes)
+	}
+	return disruptNodes[:maxConcurrent]
+}
+
+// networkPartitionTargets returns the nodes networkPartitionDisruptor
+// instances may run against, bounded by the configured
+// Disrupt.NetworkPartition.MaxConcurrent.
+func networkPartitionTargets(cfg e2e_config.NetworkPartitionConfig) []string {
+	return disruptTargets(cfg.MaxConcurrent)
+}
+
+// nodeRebootTargets returns the nodes nodeRebootDisruptor instances may run
+// against, bounded by the configured Disrupt.NodeReboot.MaxConcurrent, the
+// same way networkPartitionTargets bounds partitions.
+func nodeRebootTargets(cfg e2e_config.NodeRebootConfig) []string {
+	return disruptTargets(cfg.MaxConcurrent)
+}
+
+// MakeDisruptors launches the disruptor goroutines enabled in e2e_config.
+// Each disruptor runs independently of the others until ctx is cancelled
+// or DestroyDisruptors closes disruptorStopCh, whichever comes first.
+func MakeDisruptors(ctx context.Context) {
+	cfg := e2e_config.GetConfig().IOSoakTest.Disrupt
+	log := logr.FromContext(ctx)
+
+	if cfg.NetworkPartition.Enabled {
+		targets := networkPartitionTargets(cfg.NetworkPartition)
+		log.Info("MakeDisruptors: starting network partition disruptors", "targets", targets, "maxConcurrent", cfg.NetworkPartition.MaxConcurrent, "replicas", disruptReplicas)
+		for _, node := range targets {
+			disruptorWg.Add(1)
+			go networkPartitionDisruptor(ctx, log, node, cfg.NetworkPartition)
+		}
+	}
+
+	if cfg.NodeReboot.Enabled {
+		targets := nodeRebootTargets(cfg.NodeReboot)
+		log.Info("MakeDisruptors: starting node reboot disruptors", "targets", targets, "maxConcurrent", cfg.NodeReboot.MaxConcurrent, "replicas", disruptReplicas)
+		for _, node := range targets {
+			disruptorWg.Add(1)
+			go nodeRebootDisruptor(ctx, log, node, cfg.NodeReboot)
+		}
+	}
+
+	if cfg.Chaos.EvictionsPerMinute > 0 {
+		disruptorWg.Add(1)
+		go chaosEvictionDisruptor(ctx, log, cfg.Chaos)
+	}
+}
+
+// DestroyDisruptors signals every running disruptor to stop and waits for
+// it to undo any fault it currently has in effect.
+func DestroyDisruptors() {
+	if disruptorStopCh != nil {
+		close(disruptorStopCh)
+	}
+	disruptorWg.Wait()
+}
+
+// DisruptorsDeinit releases the disruptor subsystem's per-run state.
+func DisruptorsDeinit() {
+	disruptorStopCh = nil
+	disruptNodes = nil
+}
+
+// networkPartitionDisruptor repeatedly blackholes node's peers for
+// DurationSecs, then restores routing, waiting IntervalSecs between
+// cycles. Modelled on the self-node-remediation e2e technique of adding a
+// blackhole route on the target node rather than firing a NetworkPolicy,
+// so the partition holds even if the CNI is what's under test.
+func networkPartitionDisruptor(ctx context.Context, log logr.Logger, node string, cfg e2e_config.NetworkPartitionConfig) {
+	defer disruptorWg.Done()
+	interval := time.Duration(cfg.IntervalSecs) * time.Second
+	duration := time.Duration(cfg.DurationSecs) * time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-disruptorStopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		start := time.Now()
+		log.Info("networkPartitionDisruptor: partitioning node", "node", node)
+		if err := blackholeNode(node, true); err != nil {
+			log.Info("networkPartitionDisruptor: failed to add blackhole route", "node", node, "error", err)
+			continue
+		}
+
+		// Recorded with Ended still zero as soon as the partition takes
+		// effect, not after it's lifted, so monitor() can already
+		// attribute a pod failure observed while the partition is still
+		// in progress instead of only ones observed after it closes.
+		ev := &DisruptionEvent{Kind: DisruptNetworkPartition, Target: node, Seq: nextDisruptionSeq(), Started: start}
+		recordDisruption(ev)
+
+		stopped := false
+		select {
+		case <-ctx.Done():
+			stopped = true
+		case <-disruptorStopCh:
+			stopped = true
+		case <-time.After(duration):
+		}
+
+		if err := blackholeNode(node, false); err != nil {
+			log.Info("networkPartitionDisruptor: failed to remove blackhole route", "node", node, "error", err)
+		}
+		endDisruption(ev, time.Now())
+		if stopped {
+			return
+		}
+	}
+}
+
+// blackholeNode adds or removes a blackhole route on node for every other
+// Mayastor node's IP, executed via an SSH/privileged-DaemonSet exec so the
+// node's own nvmf/iSCSI traffic to its peers is dropped without otherwise
+// touching the node.
+func blackholeNode(node string, add bool) error {
+	peers, err := peerAddrsExcluding(node)
+	if err != nil {
+		return err
+	}
+	verb := "add"
+	if !add {
+		verb = "delete"
+	}
+	for _, peer := range peers {
+		cmd := fmt.Sprintf("ip route %s blackhole %s", verb, peer)
+		if err := common.ExecOnNode(node, cmd); err != nil {
+			return fmt.Errorf("blackholeNode: \"%s\" on %s: %v", cmd, node, err)
+		}
+	}
+	return nil
+}
+
+// peerAddrsExcluding returns the IP addresses of every other Mayastor
+// node, the set that "node" should be cut off from.
+func peerAddrsExcluding(node string) ([]string, error) {
+	nodeList, err := common.GetNodeLocs()
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	for _, n := range nodeList {
+		if n.NodeName != node && n.MayastorNode {
+			peers = append(peers, n.IPAddress)
+		}
+	}
+	return peers, nil
+}
+
+// nodeRebootDisruptor stops kubelet and reboots node for DurationSecs,
+// then lets it rejoin the cluster, waiting IntervalSecs between cycles.
+func nodeRebootDisruptor(ctx context.Context, log logr.Logger, node string, cfg e2e_config.NodeRebootConfig) {
+	defer disruptorWg.Done()
+	interval := time.Duration(cfg.IntervalSecs) * time.Second
+	duration := time.Duration(cfg.DurationSecs) * time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-disruptorStopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		start := time.Now()
+		log.Info("nodeRebootDisruptor: rebooting node", "node", node)
+		if err := common.ExecOnNode(node, "systemctl stop kubelet && reboot"); err != nil {
+			log.Info("nodeRebootDisruptor: failed to reboot node", "node", node, "error", err)
+			continue
+		}
+
+		// Recorded with Ended still zero as soon as the reboot is issued,
+		// not after the node rejoins, so monitor() can already attribute
+		// a pod failure observed while the node is still down.
+		ev := &DisruptionEvent{Kind: DisruptNodeReboot, Target: node, Seq: nextDisruptionSeq(), Started: start}
+		recordDisruption(ev)
+
+		stopped := false
+		select {
+		case <-ctx.Done():
+			stopped = true
+		case <-disruptorStopCh:
+			stopped = true
+		case <-time.After(duration):
+		}
+		endDisruption(ev, time.Now())
+		if stopped {
+			return
+		}
+	}
+}
+
+// chaosReadyWindow bounds how long chaosEvictionDisruptor waits for
+// Mayastor to report healthy again after an eviction before giving up and
+// moving on to the next tick; it is not a pass/fail check in itself, just
+// a rate limiter so evictions don't pile up faster than the cluster can
+// recover from them.
+const chaosReadyWindow = 2 * time.Minute
+
+// chaosEvictionDisruptor periodically evicts a random pod from cfg.TargetSet
+// at a rate of cfg.EvictionsPerMinute, running at most cfg.MaxParallel
+// evictions concurrently, to build a reproducible, rate-limited chaos
+// schedule rather than a wholesale destruction test.
+func chaosEvictionDisruptor(ctx context.Context, log logr.Logger, cfg e2e_config.ChaosConfig) {
+	defer disruptorWg.Done()
+	interval := time.Minute / time.Duration(cfg.EvictionsPerMinute)
+
+	// A MaxParallel of 0 would make sem unbuffered, so the non-blocking
+	// send below would always hit default and the disruptor would never
+	// evict anything; at least one eviction in flight is always allowed.
+	maxParallel := cfg.MaxParallel
+	if maxParallel < 1 {
+		log.Info("chaosEvictionDisruptor: Disrupt.Chaos.MaxParallel < 1, clamping to 1", "configured", cfg.MaxParallel)
+		maxParallel = 1
+	}
+	sem := make(chan struct{}, maxParallel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-disruptorStopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		select {
+		case sem <- struct{}{}:
+		default:
+			log.Info("chaosEvictionDisruptor: max parallel evictions already in flight, skipping this tick", "maxParallel", maxParallel)
+			continue
+		}
+
+		disruptorWg.Add(1)
+		go func() {
+			defer disruptorWg.Done()
+			defer func() { <-sem }()
+			evictRandomPod(ctx, log, cfg.TargetSet)
+		}()
+	}
+}
+
+// evictRandomPod evicts one random pod from targetSet ("mayastor" or
+// "app"), waits for Mayastor to report healthy again (bounded by
+// chaosReadyWindow), and records the eviction with a monotonic sequence
+// number in the shared disruption log.
+func evictRandomPod(ctx context.Context, log logr.Logger, targetSet string) {
+	namespace := common.NSDefault
+	labelSelector := ""
+	if targetSet == "mayastor" {
+		namespace = common.NSMayastor
+		labelSelector = "app=mayastor"
+	}
+
+	podName, err := common.PickRandomPod(namespace, labelSelector)
+	if err != nil {
+		log.Info("chaosEvictionDisruptor: failed to pick a target pod", "namespace", namespace, "error", err)
+		return
+	}
+
+	seq := nextDisruptionSeq()
+	start := time.Now()
+	log.Info("chaosEvictionDisruptor: evicting pod", "podName", podName, "namespace", namespace, "seq", seq)
+	if err := common.EvictPod(podName, namespace); err != nil {
+		log.Info("chaosEvictionDisruptor: eviction failed", "podName", podName, "error", err)
+		return
+	}
+
+	// Recorded with Ended still zero as soon as the eviction is issued, and
+	// ended on every return path, so monitor() can attribute a failure
+	// observed while the cluster is still recovering.
+	ev := &DisruptionEvent{Kind: DisruptChaosEviction, Target: podName, Seq: seq, Started: start}
+	recordDisruption(ev)
+	defer func() { endDisruption(ev, time.Now()) }()
+
+	deadline := time.Now().Add(chaosReadyWindow)
+	recovered := false
+	for time.Now().Before(deadline) {
+		if common.CheckPods(common.NSMayastor) == nil {
+			recovered = true
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+	if !recovered {
+		log.Info("chaosEvictionDisruptor: mayastor did not report healthy within the readiness window", "podName", podName, "window", chaosReadyWindow)
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"e2e-basic/common/e2e_config"
 	corev1 "k8s.io/api/core/v1"
 
+	"context"
 	"fmt"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/go-logr/logr"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -26,25 +29,35 @@ func TestIOSoak(t *testing.T) {
 	common.InitTesting(t, "IO soak test, NVMe-oF TCP and iSCSI", "io-soak")
 }
 
-func monitor() error {
+// monitor polls the Mayastor and test-pod namespaces until every job has
+// completed, one has failed, or ctx is cancelled. Cancelling ctx (a Ginkgo
+// interrupt, AfterSuite, or a global soak timeout) aborts the sleep loop
+// promptly rather than leaving it to run out the remaining jobs.
+func monitor(ctx context.Context) error {
+	log := logr.FromContext(ctx)
 	var err error
 	var failedJobs []string
+	var results []*JobResult
 	jobMap := make(map[string]IoSoakJob)
 	for _, job := range jobs {
 		jobMap[job.getPodName()] = job
 	}
 
-	logf.Log.Info("IOSoakTest monitor, checking mayastor and test pods", "jobCount", len(jobMap))
-	for ; len(jobMap) !=0 && len(failedJobs) == 0; {
-		time.Sleep(29 * time.Second)
+	log.Info("IOSoakTest monitor, checking mayastor and test pods", "jobCount", len(jobMap))
+	for ; len(jobMap) != 0 && len(failedJobs) == 0; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(29 * time.Second):
+		}
 		err = common.CheckPods(common.NSMayastor)
 		if err != nil {
-			logf.Log.Info("IOSoakTest monitor", "namespace", common.NSMayastor, "error", err)
+			log.Info("IOSoakTest monitor", "namespace", common.NSMayastor, "error", err)
 			break
 		}
 		err = common.CheckPods(common.NSDefault)
 		if err != nil {
-			logf.Log.Info("IOSoakTest monitor", "namespace", common.NSDefault, "error", err)
+			log.Info("IOSoakTest monitor", "namespace", common.NSDefault, "error", err)
 			break
 		}
 
@@ -63,29 +76,65 @@ func monitor() error {
 		for _, podName := range podNames {
 			res,err := common.CheckPodCompleted(podName, common.NSDefault)
 			if err != nil {
-				logf.Log.Info("Failed to access pod status", "podName", podName, "error", err)
+				log.Info("Failed to access pod status", "podName", podName, "error", err)
 				break
 			} else {
 				switch res  {
 				case corev1.PodPending:
-					logf.Log.Info("Unexpected! pod status pending", "podName", podName)
+					log.Info("Unexpected! pod status pending", "podName", podName)
 				case corev1.PodRunning:
 					podsRunning += 1
 				case corev1.PodSucceeded:
-					logf.Log.Info("Pod completed successfully", "podName", podName)
-					delete(jobMap, podName)
+					log.Info("Pod completed successfully", "podName", podName)
 					podsSucceeded += 1
+					if result, resErr := jobMap[podName].collectResults(ctx); resErr != nil {
+						log.Info("Failed to collect job results", "podName", podName, "error", resErr)
+					} else {
+						results = append(results, result)
+					}
+					delete(jobMap, podName)
 				case corev1.PodFailed:
-					logf.Log.Info("Pod completed with failures", "podName", podName)
 					delete(jobMap, podName)
-					failedJobs = append(failedJobs, podName)
 					podsFailed += 1
+					// The 29s poll only tells us the pod *was observed*
+					// failed just now; a node disruptor's [Started,Ended]
+					// window is usually already closed by the time we get
+					// here. Correlate against the pod's actual failure
+					// time (its last container's terminated-at) rather
+					// than the observation time, so a failure that lands
+					// inside the disruption window doesn't get counted as
+					// a genuine bug just because the next poll was late.
+					failedAt, failedAtErr := common.GetPodFailedTime(podName, common.NSDefault)
+					if failedAtErr != nil {
+						log.Info("Failed to get pod failure time, falling back to observation time", "podName", podName, "error", failedAtErr)
+						failedAt = time.Now()
+					}
+					// Node-level disruptors (network partition, reboot)
+					// are correlated by time window alone: the failing
+					// pod's own node is frequently not the node whose
+					// replica/nexus was actually disrupted, so matching
+					// against the pod's host node would miss most
+					// disruption-caused failures. Chaos evictions target
+					// one specific pod, so podDisrupted still matches by
+					// pod name.
+					nodeDisrupted := disruptionActiveAt(failedAt, DisruptNetworkPartition, DisruptNodeReboot)
+					podDisrupted := disruptedAt(podName, failedAt, DisruptChaosEviction)
+					if nodeDisrupted || podDisrupted {
+						log.Info("Pod failed during an intentional disruption, not counting as a bug", "podName", podName)
+					} else {
+						log.Info("Pod completed with failures", "podName", podName)
+						failedJobs = append(failedJobs, podName)
+					}
 				case corev1.PodUnknown:
-					logf.Log.Info("Unexpected! pod status is unknown", "podName", podName)
+					log.Info("Unexpected! pod status is unknown", "podName", podName)
 				}
 			}
 		}
-		logf.Log.Info("IO Soak test pods", "Running", podsRunning, "Succeeded", podsSucceeded, "Failed", podsFailed)
+		log.Info("IO Soak test pods", "Running", podsRunning, "Succeeded", podsSucceeded, "Failed", podsFailed)
+	}
+
+	if reportErr := writeReport(common.GetLogDir()+"/io-soak-metrics.json", results); reportErr != nil {
+		log.Info("monitor: failed to write metrics report", "error", reportErr)
 	}
 
 	if err == nil && len(failedJobs) != 0 {
@@ -97,7 +146,8 @@ func monitor() error {
 /// proto - protocol "nvmf" or "isci"
 /// replicas - number of replicas for each volume
 /// loadFactor - number of volumes for each mayastor instance
-func IOSoakTest(protocols []common.ShareProto, replicas int, loadFactor int, duration time.Duration, disruptorCount int) {
+func IOSoakTest(ctx context.Context, protocols []common.ShareProto, replicas int, loadFactor int, duration time.Duration, disruptorCount int, workloadNames []string) {
+	log := logr.FromContext(ctx)
 	nodeList, err := common.GetNodeLocs()
 	Expect(err).ToNot(HaveOccurred())
 
@@ -108,7 +158,7 @@ func IOSoakTest(protocols []common.ShareProto, replicas int, loadFactor int, dur
 	sort.Slice(nodeList, func(i, j int) bool { return nodeList[i].NodeName < nodeList[j].NodeName })
 	for i, node := range nodeList {
 		if node.MayastorNode && !node.MasterNode {
-			logf.Log.Info("MayastorNode", "name", node.NodeName, "index", i)
+			log.Info("MayastorNode", "name", node.NodeName, "index", i)
 			jobCount += loadFactor
 			numMayastorNodes += 1
 			nodes = append(nodes, node.NodeName)
@@ -122,103 +172,127 @@ func IOSoakTest(protocols []common.ShareProto, replicas int, loadFactor int, dur
 			common.LabelNode(node, NodeSelectorKey, NodeSelectorAppValue)
 		}
 	}
+	// Registered as a defer (rather than left as a final statement) so a
+	// cancelled ctx - which fails the Expects below and panics out of
+	// IOSoakTest via Gomega's fail handler - still unlabels the nodes
+	// instead of leaving them labelled for the next run.
+	defer func() {
+		for i, node := range nodes {
+			if i%2 == 0 {
+				common.UnlabelNode(node, NodeSelectorKey)
+			}
+		}
+	}()
 
 	Expect(replicas <= numMayastorNodes).To(BeTrue())
-	logf.Log.Info("IOSoakTest", "jobs", jobCount, "volumes", jobCount, "test pods", jobCount)
+	log.Info("IOSoakTest", "jobs", jobCount, "volumes", jobCount, "test pods", jobCount)
 
 	for _, proto := range protocols {
 		scName := fmt.Sprintf("io-soak-%s", proto)
-		logf.Log.Info("Creating", "storage class", scName)
+		log.Info("Creating", "storage class", scName)
 		err = common.MkStorageClass(scName, replicas, proto, common.NSDefault)
 		Expect(err).ToNot(HaveOccurred())
 		scNames = append(scNames, scName)
 	}
-
-	// Create the set of jobs
-	idx := 1
-	for idx <= jobCount {
+	defer func() {
+		log.Info("Deleting storage classes")
 		for _, scName := range scNames {
-			if idx > jobCount {
-				break
+			if err := common.RmStorageClass(scName); err != nil {
+				log.Info("Failed to delete storage class", "scName", scName, "error", err)
 			}
-			logf.Log.Info("Creating", "job", "fio filesystem job", "id", idx)
-			jobs = append(jobs, MakeFioFsJob(scName, idx, duration))
-			idx++
+		}
+	}()
 
-			if idx > jobCount {
-				break
+	backends, err := SelectWorkloadBackends(workloadNames)
+	Expect(err).ToNot(HaveOccurred())
+
+	// Create the set of jobs, cycling backend x storage class x {fs,raw}
+	// until jobCount is reached. A mode the backend doesn't support (e.g.
+	// sysbench/bonnie++ have no raw-block mode) is skipped rather than
+	// given a job slot, so it can't consume part of jobCount as an
+	// indistinguishable second fs job mislabeled "raw".
+	idx := 1
+modeLoop:
+	for idx <= jobCount {
+		for _, backend := range backends {
+			for _, scName := range scNames {
+				for _, mode := range []BlockOrFS{Fs, RawBlock} {
+					if !backend.SupportsMode(mode) {
+						continue
+					}
+					if idx > jobCount {
+						break modeLoop
+					}
+					log.Info("Creating", "job", backend.Name(), "mode", mode.String(), "id", idx)
+					jobs = append(jobs, backend.MakeJob(ctx, scName, idx, mode, duration))
+					idx++
+				}
 			}
-			logf.Log.Info("Creating", "job", "fio raw block job", "id", idx)
-			jobs = append(jobs, MakeFioRawBlockJob(scName, idx, duration))
-			idx++
 		}
 	}
 
-	logf.Log.Info("Creating volumes")
+	log.Info("Creating volumes")
 	// Create the job volumes
 	for _, job := range jobs {
-		job.makeVolume()
+		job.makeVolume(ctx)
 	}
+	defer func() {
+		log.Info("Deleting volumes")
+		for _, job := range jobs {
+			job.removeVolume(ctx)
+		}
+	}()
 
-	logf.Log.Info("Creating test pods")
+	log.Info("Creating test pods")
 	// Create the job test pods
 	for _, job := range jobs {
-		pod, err := job.makeTestPod(AppNodeSelector)
+		pod, err := job.makeTestPod(ctx, AppNodeSelector)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(pod).ToNot(BeNil())
 	}
+	defer func() {
+		log.Info("Deleting test pods")
+		for _, job := range jobs {
+			if err := job.removeTestPod(ctx); err != nil {
+				log.Info("Failed to delete test pod", "podName", job.getPodName(), "error", err)
+			}
+		}
+	}()
 
 	// Empirically allocated PodReadyTime seconds for each pod to transition to ready
 	timeoutSecs := PodReadyTime * len(jobs)
 	if timeoutSecs < 60 {
 		timeoutSecs = 60
 	}
-	logf.Log.Info("Waiting for test pods to be ready", "timeout seconds", timeoutSecs, "jobCount", len(jobs))
+	log.Info("Waiting for test pods to be ready", "timeout seconds", timeoutSecs, "jobCount", len(jobs))
 
 	// Wait for the test pods to be ready
 	allReady := false
-	for to:=0; to< timeoutSecs && !allReady; to+=1 {
-		time.Sleep(1* time.Second)
+	for to := 0; to < timeoutSecs && !allReady && ctx.Err() == nil; to += 1 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(1 * time.Second):
+		}
 		allReady = true
 		for _, job := range jobs {
 			allReady = allReady && common.IsPodRunning(job.getPodName(), common.NSDefault)
 		}
 	}
+	Expect(ctx.Err()).ToNot(HaveOccurred(), "Context cancelled waiting for jobs to be ready")
 	Expect(allReady).To(BeTrue(), "Timeout waiting to jobs to be ready")
 
-	logf.Log.Info("Starting disruptor pods")
-	DisruptorsInit(protocols, replicas)
-	MakeDisruptors()
-
-	logf.Log.Info("Waiting for test execution to complete on all test pods")
-	err = monitor()
+	log.Info("Starting disruptor pods")
+	DisruptorsInit(ctx, protocols, replicas)
+	MakeDisruptors(ctx)
+	defer func() {
+		log.Info("Destroying disruptors")
+		DestroyDisruptors()
+		DisruptorsDeinit()
+	}()
+
+	log.Info("Waiting for test execution to complete on all test pods")
+	err = monitor(ctx)
 	Expect(err).To(BeNil(), "Failed runs")
-
-	logf.Log.Info("All runs complete, deleting test pods")
-	DestroyDisruptors()
-	DisruptorsDeinit()
-
-	for _, job := range jobs {
-		err := job.removeTestPod()
-		Expect(err).ToNot(HaveOccurred())
-	}
-
-	logf.Log.Info("All runs complete, deleting volumes")
-	for _, job := range jobs {
-		job.removeVolume()
-	}
-
-	logf.Log.Info("All runs complete, deleting storage classes")
-	for _, scName := range scNames {
-		err = common.RmStorageClass(scName)
-		Expect(err).ToNot(HaveOccurred())
-	}
-
-	for i, node := range nodes {
-		if i%2 == 0 {
-			common.UnlabelNode(node, NodeSelectorKey)
-		}
-	}
 }
 
 var _ = Describe("Mayastor Volume IO soak test", func() {
@@ -242,11 +316,16 @@ var _ = Describe("Mayastor Volume IO soak test", func() {
 		}
 		duration, err := time.ParseDuration(e2eCfg.IOSoakTest.Duration)
 		Expect(err).ToNot(HaveOccurred(), "Duration configuration string format is invalid.")
-		logf.Log.Info("Parameters",
+		log := logf.Log.WithValues("test", "io-soak")
+		log.Info("Parameters",
 			"replicas", replicas, "loadFactor", loadFactor,
 			"duration", duration,
 			"disrupt", e2eCfg.IOSoakTest.Disrupt)
-		IOSoakTest(protocols, replicas, loadFactor, duration, disruptorCount)
+		// A global soak timeout well beyond the run duration guards
+		// against a stuck run hanging the whole suite.
+		ctx, cancel := context.WithTimeout(logr.NewContext(context.Background(), log), duration+10*time.Minute)
+		defer cancel()
+		IOSoakTest(ctx, protocols, replicas, loadFactor, duration, disruptorCount, e2eCfg.IOSoakTest.Workloads)
 	})
 })
 
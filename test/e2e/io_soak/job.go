@@ -0,0 +1,319 @@
+package io_soak
+
+import (
+	"e2e-basic/common"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IoSoakJob is a single long-running IO workload: a volume, the test pod
+// driving IO against it, and the bookkeeping needed to create/tear both
+// down and to pull the job's results once the pod completes. Every method
+// but getPodName takes a context so that a cancelled/timed-out run aborts the underlying
+// kubectl/exec call instead of blocking IOSoakTest's teardown.
+type IoSoakJob interface {
+	makeVolume(ctx context.Context) error
+	makeTestPod(ctx context.Context, nodeSelector map[string]string) (*corev1.Pod, error)
+	removeTestPod(ctx context.Context) error
+	removeVolume(ctx context.Context) error
+	getPodName() string
+	collectResults(ctx context.Context) (*JobResult, error)
+}
+
+// fioResultPath is where the fio container is asked to write its JSON
+// summary; monitor() execs into the pod to fetch it once the pod
+// reaches PodSucceeded.
+const fioResultPath = "/tmp/fio.json"
+
+// JobResult is a fio --output-format=json summary reduced to the figures
+// an IO soak run cares about for regression tracking.
+type JobResult struct {
+	JobID      int
+	ScName     string
+	Proto      string
+	Mode       string
+	ReadIOPS   float64
+	WriteIOPS  float64
+	ReadBWKiB  float64
+	WriteBWKiB float64
+	ClatP50Us  float64
+	ClatP95Us  float64
+	ClatP99Us  float64
+	// LatencySeries is the per-pod completion-latency-vs-time series for
+	// the run. Only populated for fio jobs,
+	// taken from fio's own --write_lat_log rather than a separate
+	// sampling loop; nil for backends with no comparable per-sample log.
+	LatencySeries []LatencySample `json:",omitempty"`
+}
+
+// LatencySample is one point of a JobResult's LatencySeries.
+type LatencySample struct {
+	TimeMs int64
+	ClatUs float64
+}
+
+// fioJSON mirrors the subset of fio's --output-format=json schema that
+// JobResult is built from.
+type fioJSON struct {
+	Jobs []struct {
+		Read struct {
+			IOPS   float64 `json:"iops"`
+			BWKiB  float64 `json:"bw"`
+			ClatNS struct {
+				Percentile map[string]float64 `json:"percentile"`
+			} `json:"clat_ns"`
+		} `json:"read"`
+		Write struct {
+			IOPS   float64 `json:"iops"`
+			BWKiB  float64 `json:"bw"`
+			ClatNS struct {
+				Percentile map[string]float64 `json:"percentile"`
+			} `json:"clat_ns"`
+		} `json:"write"`
+	} `json:"jobs"`
+}
+
+// fioJob is the shape shared by the filesystem and raw-block fio
+// IoSoakJob variants; only the volume-claim machinery differs between the
+// two, so both embed it and share its fio invocation and result parsing.
+type fioJob struct {
+	id       int
+	scName   string
+	proto    string
+	mode     string
+	pvcName  string
+	podName  string
+	duration time.Duration
+	log      logr.Logger
+}
+
+// newFioJob builds the shared job state and attaches a logger carrying
+// jobID/scName/proto/podName once, via WithValues, so every log line the
+// job emits is consistently tagged without re-stating them at each call
+// site.
+func newFioJob(ctx context.Context, scName string, id int, mode string, duration time.Duration) fioJob {
+	proto := strings.TrimPrefix(scName, "io-soak-")
+	podName := fmt.Sprintf("io-soak-%s-%d", mode, id)
+	return fioJob{
+		id:       id,
+		scName:   scName,
+		proto:    proto,
+		mode:     mode,
+		pvcName:  fmt.Sprintf("io-soak-pvc-%d", id),
+		podName:  podName,
+		duration: duration,
+		log:      logr.FromContext(ctx).WithValues("jobID", id, "scName", scName, "proto", proto, "podName", podName),
+	}
+}
+
+func (j *fioJob) getPodName() string {
+	return j.podName
+}
+
+// fioFileSizeBytes is the size fio is told to fill/operate on for a
+// filesystem-backed target; a raw block target already has a fixed size
+// so fio is left to size itself off the device.
+const fioFileSizeBytes = "1G"
+
+// fioLatLogPrefix is the --write_lat_log prefix fio is asked to use; fio
+// appends "_clat.log" itself, giving the completion-latency time series
+// collectResults reads into JobResult.LatencySeries.
+const fioLatLogPrefix = "/tmp/fio-lat"
+
+// fioLatLogAvgMsec buckets --write_lat_log samples into 5s windows rather
+// than one line per IO, so a multi-minute soak run's LatencySeries stays a
+// reasonably sized time series instead of one entry per completed IO.
+const fioLatLogAvgMsec = 5000
+
+// fioArgs is the common fio invocation for both job variants; it asks fio
+// to emit a JSON summary to fioResultPath on completion so collectResults
+// can pull real metrics instead of just a pass/fail pod phase, and a
+// latency-vs-time log via --write_lat_log for JobResult.LatencySeries.
+// --runtime must be set alongside --time_based or fio refuses to start
+// ("time_based requires runtime to be set").
+func fioArgs(target string, duration time.Duration, size string) []string {
+	args := []string{
+		"--name=io-soak",
+		"--filename=" + target,
+		"--output-format=json",
+		"--output=" + fioResultPath,
+		"--rw=randrw",
+		"--bs=4k",
+		"--time_based",
+		fmt.Sprintf("--runtime=%d", int(duration.Seconds())),
+		"--write_lat_log=" + fioLatLogPrefix,
+		fmt.Sprintf("--log_avg_msec=%d", fioLatLogAvgMsec),
+	}
+	if size != "" {
+		args = append(args, "--size="+size)
+	}
+	return args
+}
+
+// parseLatencyLog parses fio's --write_lat_log completion-latency log: one
+// "time_msec,value_nsec,direction,block_size[,offset]" line per sample.
+// Malformed lines are skipped rather than failing the whole series, since a
+// partial time series is still useful and the JSON summary it complements
+// has already been parsed successfully by the time this runs.
+func parseLatencyLog(raw string) []LatencySample {
+	var samples []LatencySample
+	for _, line := range strings.Split(strings.TrimSpace(raw), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			continue
+		}
+		timeMs, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			continue
+		}
+		clatNs, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, LatencySample{TimeMs: timeMs, ClatUs: clatNs / 1000})
+	}
+	return samples
+}
+
+// collectResults execs into the completed pod, fetches the fio JSON
+// summary and reduces it to a JobResult. It is only valid to call once
+// the pod has reached PodSucceeded.
+func collectResults(ctx context.Context, podName string, jobID int, scName string, proto string, mode string) (*JobResult, error) {
+	raw, err := common.ExecOnPodWithContext(ctx, podName, common.NSDefault, fmt.Sprintf("cat %s", fioResultPath))
+	if err != nil {
+		return nil, fmt.Errorf("collectResults: failed to fetch %s from %s: %v", fioResultPath, podName, err)
+	}
+
+	var parsed fioJSON
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("collectResults: failed to parse fio json from %s: %v", podName, err)
+	}
+	if len(parsed.Jobs) == 0 {
+		return nil, fmt.Errorf("collectResults: no jobs in fio json from %s", podName)
+	}
+	job := parsed.Jobs[0]
+
+	result := &JobResult{
+		JobID:      jobID,
+		ScName:     scName,
+		Proto:      proto,
+		Mode:       mode,
+		ReadIOPS:   job.Read.IOPS,
+		WriteIOPS:  job.Write.IOPS,
+		ReadBWKiB:  job.Read.BWKiB,
+		WriteBWKiB: job.Write.BWKiB,
+		ClatP50Us:  job.Read.ClatNS.Percentile["50.000000"] / 1000,
+		ClatP95Us:  job.Read.ClatNS.Percentile["95.000000"] / 1000,
+		ClatP99Us:  job.Read.ClatNS.Percentile["99.000000"] / 1000,
+	}
+
+	// Best effort: the json summary above is the result that matters, so a
+	// missing/unreadable clat log only drops the latency-vs-time series,
+	// not the whole result.
+	if latRaw, latErr := common.ExecOnPodWithContext(ctx, podName, common.NSDefault, fmt.Sprintf("cat %s_clat.log", fioLatLogPrefix)); latErr != nil {
+		logr.FromContext(ctx).Info("collectResults: failed to fetch latency log", "podName", podName, "error", latErr)
+	} else {
+		result.LatencySeries = parseLatencyLog(latRaw)
+	}
+
+	return result, nil
+}
+
+// fioFsJob runs fio against a file on a filesystem-mounted PVC.
+type fioFsJob struct {
+	fioJob
+}
+
+// MakeFioFsJob creates an IoSoakJob that runs fio against a file on a
+// filesystem volume for duration.
+func MakeFioFsJob(ctx context.Context, scName string, id int, duration time.Duration) IoSoakJob {
+	return &fioFsJob{fioJob: newFioJob(ctx, scName, id, "fs", duration)}
+}
+
+func (j *fioFsJob) makeVolume(ctx context.Context) error {
+	j.log.Info("Creating volume")
+	return common.MkPVC(j.pvcName, j.scName, common.VolFileSystem)
+}
+
+func (j *fioFsJob) makeTestPod(ctx context.Context, nodeSelector map[string]string) (*corev1.Pod, error) {
+	pod, err := common.MkFioPod(j.podName, j.pvcName, common.VolFileSystem, nodeSelector, fioArgs("/volume/io-soak.dat", j.duration, fioFileSizeBytes))
+	if err == nil && pod != nil {
+		j.log = j.log.WithValues("nodeName", pod.Spec.NodeName)
+	}
+	return pod, err
+}
+
+func (j *fioFsJob) removeTestPod(ctx context.Context) error {
+	j.log.Info("Removing test pod")
+	return common.RmPod(j.podName, common.NSDefault)
+}
+
+func (j *fioFsJob) removeVolume(ctx context.Context) error {
+	j.log.Info("Removing volume")
+	return common.RmPVC(j.pvcName, j.scName)
+}
+
+func (j *fioFsJob) collectResults(ctx context.Context) (*JobResult, error) {
+	return collectResults(ctx, j.podName, j.id, j.scName, j.proto, "fs")
+}
+
+// fioRawBlockJob runs fio directly against a raw block volume.
+type fioRawBlockJob struct {
+	fioJob
+}
+
+// MakeFioRawBlockJob creates an IoSoakJob that runs fio against a raw
+// block volume for duration.
+func MakeFioRawBlockJob(ctx context.Context, scName string, id int, duration time.Duration) IoSoakJob {
+	return &fioRawBlockJob{fioJob: newFioJob(ctx, scName, id, "block", duration)}
+}
+
+func (j *fioRawBlockJob) makeVolume(ctx context.Context) error {
+	j.log.Info("Creating volume")
+	return common.MkPVC(j.pvcName, j.scName, common.VolRawBlock)
+}
+
+func (j *fioRawBlockJob) makeTestPod(ctx context.Context, nodeSelector map[string]string) (*corev1.Pod, error) {
+	pod, err := common.MkFioPod(j.podName, j.pvcName, common.VolRawBlock, nodeSelector, fioArgs(common.RawBlockDevicePath, j.duration, ""))
+	if err == nil && pod != nil {
+		j.log = j.log.WithValues("nodeName", pod.Spec.NodeName)
+	}
+	return pod, err
+}
+
+func (j *fioRawBlockJob) removeTestPod(ctx context.Context) error {
+	j.log.Info("Removing test pod")
+	return common.RmPod(j.podName, common.NSDefault)
+}
+
+func (j *fioRawBlockJob) removeVolume(ctx context.Context) error {
+	j.log.Info("Removing volume")
+	return common.RmPVC(j.pvcName, j.scName)
+}
+
+func (j *fioRawBlockJob) collectResults(ctx context.Context) (*JobResult, error) {
+	return collectResults(ctx, j.podName, j.id, j.scName, j.proto, "block")
+}
+
+// writeReport writes results to path as a JSON array with one entry per
+// job, each tagged with its ScName/Proto/Mode, so CI can diff performance
+// across builds instead of only seeing a boolean pass/fail. It does not
+// aggregate or group results across jobs.
+func writeReport(path string, results []*JobResult) error {
+	buf, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writeReport: failed to marshal results: %v", err)
+	}
+	if err := common.WriteFile(path, buf); err != nil {
+		return fmt.Errorf("writeReport: failed to write %s: %v", path, err)
+	}
+	return nil
+}
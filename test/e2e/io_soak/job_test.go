@@ -0,0 +1,25 @@
+package io_soak
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLatencyLog(t *testing.T) {
+	raw := "1000,12345,0,4096\n2000,23456,1,4096\nmalformed line\n5000,34567,0,4096\n"
+	got := parseLatencyLog(raw)
+	want := []LatencySample{
+		{TimeMs: 1000, ClatUs: 12.345},
+		{TimeMs: 2000, ClatUs: 23.456},
+		{TimeMs: 5000, ClatUs: 34.567},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLatencyLog(%q) = %+v, want %+v", raw, got, want)
+	}
+}
+
+func TestParseLatencyLogEmpty(t *testing.T) {
+	if got := parseLatencyLog(""); got != nil {
+		t.Errorf("parseLatencyLog(\"\") = %+v, want nil", got)
+	}
+}
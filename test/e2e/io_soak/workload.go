@@ -0,0 +1,312 @@
+package io_soak
+
+import (
+	"e2e-basic/common"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BlockOrFS selects whether a workload job targets a raw block volume or
+// a filesystem-mounted one.
+type BlockOrFS int
+
+const (
+	Fs BlockOrFS = iota
+	RawBlock
+)
+
+func (m BlockOrFS) String() string {
+	if m == RawBlock {
+		return "block"
+	}
+	return "fs"
+}
+
+// WorkloadMetrics is the result a WorkloadBackend extracts from its own
+// tool-specific output, normalised enough that a soak report can compare
+// backends with otherwise unrelated output formats.
+type WorkloadMetrics struct {
+	OpsPerSec    float64
+	P95LatencyUs float64
+}
+
+// WorkloadBackend is a pluggable IO workload shape. IOSoakTest cycles
+// through every registered backend x protocol x {fs,raw} to build its job
+// set, so exercising a new access pattern is a matter of registering a
+// backend rather than changing IOSoakTest itself.
+type WorkloadBackend interface {
+	Name() string
+	// SupportsMode reports whether the backend can run in mode; a backend
+	// whose tool has no meaningful raw-block mode (e.g. it needs a
+	// filesystem for its own files) returns false for RawBlock so the
+	// composition loop in IOSoakTest doesn't give it a job slot it would
+	// silently run as fs anyway.
+	SupportsMode(mode BlockOrFS) bool
+	MakeJob(ctx context.Context, scName string, id int, mode BlockOrFS, duration time.Duration) IoSoakJob
+	ParseResults(raw []byte) (WorkloadMetrics, error)
+}
+
+var workloadBackends = make(map[string]WorkloadBackend)
+
+// RegisterWorkloadBackend makes a backend available for selection via
+// e2e_config.IOSoakTest.Workloads.
+func RegisterWorkloadBackend(b WorkloadBackend) {
+	workloadBackends[b.Name()] = b
+}
+
+// SelectWorkloadBackends returns the registered backends named in names,
+// in that order. An unrecognised name is a configuration error, not a
+// silent skip.
+func SelectWorkloadBackends(names []string) ([]WorkloadBackend, error) {
+	var selected []WorkloadBackend
+	for _, name := range names {
+		b, ok := workloadBackends[name]
+		if !ok {
+			return nil, fmt.Errorf("SelectWorkloadBackends: unknown workload backend %q", name)
+		}
+		selected = append(selected, b)
+	}
+	return selected, nil
+}
+
+func init() {
+	RegisterWorkloadBackend(&fioBackend{})
+	RegisterWorkloadBackend(&sysbenchBackend{})
+	RegisterWorkloadBackend(&bonnieBackend{})
+}
+
+// fioBackend adapts the existing fio jobs to the WorkloadBackend
+// interface; it is the default and only backend prior to this change.
+type fioBackend struct{}
+
+func (fioBackend) Name() string { return "fio" }
+
+func (fioBackend) SupportsMode(mode BlockOrFS) bool { return true }
+
+func (fioBackend) MakeJob(ctx context.Context, scName string, id int, mode BlockOrFS, duration time.Duration) IoSoakJob {
+	if mode == RawBlock {
+		return MakeFioRawBlockJob(ctx, scName, id, duration)
+	}
+	return MakeFioFsJob(ctx, scName, id, duration)
+}
+
+func (fioBackend) ParseResults(raw []byte) (WorkloadMetrics, error) {
+	var parsed fioJSON
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return WorkloadMetrics{}, fmt.Errorf("fioBackend.ParseResults: %v", err)
+	}
+	if len(parsed.Jobs) == 0 {
+		return WorkloadMetrics{}, fmt.Errorf("fioBackend.ParseResults: no jobs in fio output")
+	}
+	job := parsed.Jobs[0]
+	return WorkloadMetrics{
+		OpsPerSec:    job.Read.IOPS + job.Write.IOPS,
+		P95LatencyUs: job.Read.ClatNS.Percentile["95.000000"] / 1000,
+	}, nil
+}
+
+// workloadJob is the shape shared by the non-fio backends: a single pod
+// running a packaged benchmark binary against a mounted filesystem PVC,
+// with its result file fetched once the pod succeeds.
+type workloadJob struct {
+	id         int
+	scName     string
+	proto      string
+	pvcName    string
+	podName    string
+	resultPath string
+}
+
+func newWorkloadJob(scName string, id int, backend string, resultPath string) workloadJob {
+	return workloadJob{
+		id:         id,
+		scName:     scName,
+		proto:      strings.TrimPrefix(scName, "io-soak-"),
+		pvcName:    fmt.Sprintf("io-soak-pvc-%d", id),
+		podName:    fmt.Sprintf("io-soak-%s-%d", backend, id),
+		resultPath: resultPath,
+	}
+}
+
+func (j *workloadJob) getPodName() string {
+	return j.podName
+}
+
+func (j *workloadJob) makeVolume(ctx context.Context) error {
+	return common.MkPVC(j.pvcName, j.scName, common.VolFileSystem)
+}
+
+func (j *workloadJob) removeTestPod(ctx context.Context) error {
+	return common.RmPod(j.podName, common.NSDefault)
+}
+
+func (j *workloadJob) removeVolume(ctx context.Context) error {
+	return common.RmPVC(j.pvcName, j.scName)
+}
+
+func (j *workloadJob) fetchRaw(ctx context.Context) ([]byte, error) {
+	raw, err := common.ExecOnPodWithContext(ctx, j.podName, common.NSDefault, fmt.Sprintf("cat %s", j.resultPath))
+	return []byte(raw), err
+}
+
+// sysbenchJob runs sysbench's OLTP read/write benchmark against a SQLite
+// file on a mounted filesystem PVC, exercising the small, transactional
+// fsync-heavy access pattern of a database workload rather than fio's
+// streaming IO.
+type sysbenchJob struct {
+	workloadJob
+	duration time.Duration
+}
+
+const sysbenchResultPath = "/tmp/sysbench.log"
+
+// MakeSysbenchJob creates an IoSoakJob running sysbench oltp_read_write
+// for duration. Raw-block volumes have no filesystem for sysbench's
+// SQLite backing file to live on, so this backend always runs in fs mode.
+func MakeSysbenchJob(ctx context.Context, scName string, id int, duration time.Duration) IoSoakJob {
+	return &sysbenchJob{workloadJob: newWorkloadJob(scName, id, "sysbench", sysbenchResultPath), duration: duration}
+}
+
+func (j *sysbenchJob) makeTestPod(ctx context.Context, nodeSelector map[string]string) (*corev1.Pod, error) {
+	secs := int(j.duration.Seconds())
+	cmd := fmt.Sprintf(
+		"sysbench oltp_read_write --db-driver=sqlite3 --sqlite-db=/volume/sysbench.db --threads=4 --time=%d prepare && "+
+			"sysbench oltp_read_write --db-driver=sqlite3 --sqlite-db=/volume/sysbench.db --threads=4 --time=%d run > %s",
+		secs, secs, sysbenchResultPath)
+	return common.MkWorkloadPod(j.podName, j.pvcName, common.VolFileSystem, nodeSelector, "sysbench", []string{"sh", "-c", cmd})
+}
+
+func (j *sysbenchJob) collectResults(ctx context.Context) (*JobResult, error) {
+	raw, err := j.fetchRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("sysbenchJob.collectResults: %v", err)
+	}
+	metrics, err := (sysbenchBackend{}).ParseResults(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &JobResult{JobID: j.id, ScName: j.scName, Proto: j.proto, Mode: "sysbench", ReadIOPS: metrics.OpsPerSec, ClatP95Us: metrics.P95LatencyUs}, nil
+}
+
+type sysbenchBackend struct{}
+
+func (sysbenchBackend) Name() string { return "sysbench" }
+
+// SupportsMode is fs-only: sysbench's SQLite backing file has nowhere to
+// live on a raw block volume.
+func (sysbenchBackend) SupportsMode(mode BlockOrFS) bool { return mode == Fs }
+
+func (sysbenchBackend) MakeJob(ctx context.Context, scName string, id int, mode BlockOrFS, duration time.Duration) IoSoakJob {
+	return MakeSysbenchJob(ctx, scName, id, duration)
+}
+
+var sysbenchTxRe = regexp.MustCompile(`transactions:\s+\d+\s+\(([0-9.]+) per sec\.\)`)
+var sysbench95Re = regexp.MustCompile(`95th percentile:\s+([0-9.]+)`)
+
+func (sysbenchBackend) ParseResults(raw []byte) (WorkloadMetrics, error) {
+	metrics := WorkloadMetrics{}
+	if m := sysbenchTxRe.FindSubmatch(raw); m != nil {
+		metrics.OpsPerSec, _ = strconv.ParseFloat(string(m[1]), 64)
+	}
+	if m := sysbench95Re.FindSubmatch(raw); m != nil {
+		ms, _ := strconv.ParseFloat(string(m[1]), 64)
+		metrics.P95LatencyUs = ms * 1000
+	}
+	if metrics.OpsPerSec == 0 {
+		return metrics, fmt.Errorf("sysbenchBackend.ParseResults: no transaction rate found in output")
+	}
+	return metrics, nil
+}
+
+// bonnieJob runs bonnie++ against a mounted filesystem PVC, a
+// metadata-heavy small-file create/stat/delete benchmark complementary to
+// fio's large sequential/random streams.
+type bonnieJob struct {
+	workloadJob
+	duration time.Duration
+}
+
+const bonnieResultPath = "/tmp/bonnie.csv"
+
+// MakeBonnieJob creates an IoSoakJob running a single bonnie++ pass, which
+// runs to completion; duration is stored but not applied to it. Like sysbench, bonnie++ needs a filesystem to create/stat/delete small
+// files on, so this backend always runs in fs mode.
+func MakeBonnieJob(ctx context.Context, scName string, id int, duration time.Duration) IoSoakJob {
+	return &bonnieJob{workloadJob: newWorkloadJob(scName, id, "bonnie", bonnieResultPath), duration: duration}
+}
+
+func (j *bonnieJob) makeTestPod(ctx context.Context, nodeSelector map[string]string) (*corev1.Pod, error) {
+	cmd := fmt.Sprintf("bonnie++ -d /volume -u root -q > %s", bonnieResultPath)
+	return common.MkWorkloadPod(j.podName, j.pvcName, common.VolFileSystem, nodeSelector, "bonnie++", []string{"sh", "-c", cmd})
+}
+
+func (j *bonnieJob) collectResults(ctx context.Context) (*JobResult, error) {
+	raw, err := j.fetchRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bonnieJob.collectResults: %v", err)
+	}
+	metrics, err := (bonnieBackend{}).ParseResults(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &JobResult{JobID: j.id, ScName: j.scName, Proto: j.proto, Mode: "bonnie++", ReadIOPS: metrics.OpsPerSec}, nil
+}
+
+type bonnieBackend struct{}
+
+func (bonnieBackend) Name() string { return "bonnie++" }
+
+// SupportsMode is fs-only: bonnie++ creates/stats/deletes small files, which
+// needs a filesystem to do it on.
+func (bonnieBackend) SupportsMode(mode BlockOrFS) bool { return mode == Fs }
+
+func (bonnieBackend) MakeJob(ctx context.Context, scName string, id int, mode BlockOrFS, duration time.Duration) IoSoakJob {
+	return MakeBonnieJob(ctx, scName, id, duration)
+}
+
+// Field indices into the bonnie++ 1.96/1.97 -q (quasi-machine) CSV line
+// (see bon_csv2html.c's field list): name,date,file_size,put_block_rate,
+// put_block_cpu,rewrite_rate,rewrite_cpu,get_block_rate,get_block_cpu,
+// random_seeks_rate,random_seeks_cpu,seq_create_num,seq_create_rate,... ,
+// rand_create_num,rand_create_rate,... . Fields 7/9 (get_block_rate,
+// random_seeks_rate) are the streaming-IO section and look superficially
+// similar, but the metadata-heavy small-file create rate this backend
+// exists to measure is seqCreateField/randCreateField.
+const (
+	bonnieSeqCreateField  = 12
+	bonnieRandCreateField = 19
+)
+
+// bonnie++'s -q (quasi-machine) output is a single CSV line; see
+// bonnieSeqCreateField/bonnieRandCreateField for which fields are read.
+func (bonnieBackend) ParseResults(raw []byte) (WorkloadMetrics, error) {
+	fields := splitCSVLine(raw)
+	if len(fields) <= bonnieRandCreateField {
+		return WorkloadMetrics{}, fmt.Errorf("bonnieBackend.ParseResults: unexpected bonnie++ csv: %d fields, want at least %d", len(fields), bonnieRandCreateField+1)
+	}
+	seqCreate, err := strconv.ParseFloat(fields[bonnieSeqCreateField], 64)
+	if err != nil {
+		return WorkloadMetrics{}, fmt.Errorf("bonnieBackend.ParseResults: sequential-create field %q: %v", fields[bonnieSeqCreateField], err)
+	}
+	randCreate, err := strconv.ParseFloat(fields[bonnieRandCreateField], 64)
+	if err != nil {
+		return WorkloadMetrics{}, fmt.Errorf("bonnieBackend.ParseResults: random-create field %q: %v", fields[bonnieRandCreateField], err)
+	}
+	return WorkloadMetrics{OpsPerSec: (seqCreate + randCreate) / 2}, nil
+}
+
+func splitCSVLine(raw []byte) []string {
+	line := string(raw)
+	if idx := regexp.MustCompile(`\r?\n`).FindStringIndex(line); idx != nil {
+		line = line[:idx[0]]
+	}
+	return regexp.MustCompile(`,`).Split(line, -1)
+}
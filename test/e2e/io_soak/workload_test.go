@@ -0,0 +1,27 @@
+package io_soak
+
+import (
+	"testing"
+)
+
+// sampleBonnieCSVLine is a bonnie++ 1.97 -q (quasi-machine) output line,
+// shaped per bon_csv2html.c's field list, with seq_create_rate=5800 at
+// field 12 and rand_create_rate=5700 at field 19.
+const sampleBonnieCSVLine = "myhost,1.97,4G,102400,12,45210,9,156000,20,850,5,16,5800,52,603000,99,4200,48,16,5700,53,598000,99,4100,47"
+
+func TestBonnieBackendParseResults(t *testing.T) {
+	metrics, err := (bonnieBackend{}).ParseResults([]byte(sampleBonnieCSVLine))
+	if err != nil {
+		t.Fatalf("ParseResults: unexpected error: %v", err)
+	}
+	const wantOpsPerSec = (5800.0 + 5700.0) / 2
+	if metrics.OpsPerSec != wantOpsPerSec {
+		t.Errorf("OpsPerSec = %v, want %v", metrics.OpsPerSec, wantOpsPerSec)
+	}
+}
+
+func TestBonnieBackendParseResultsTooFewFields(t *testing.T) {
+	if _, err := (bonnieBackend{}).ParseResults([]byte("myhost,1.97,4G")); err == nil {
+		t.Error("ParseResults: expected an error for a truncated csv line, got nil")
+	}
+}